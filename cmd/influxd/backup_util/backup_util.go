@@ -2,13 +2,17 @@ package backup_util
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"encoding/json"
+	"github.com/influxdata/influxdb/services/meta"
 	"github.com/influxdata/influxdb/services/snapshotter"
 	"io/ioutil"
 	"path/filepath"
@@ -144,3 +148,115 @@ func DBRetentionAndShardFromPath(path string) (db, retention, shard string, err
 
 	return a[0], a[1], a[2], nil
 }
+
+// VerifyRestore compares the cluster served by c against the backup described
+// by m, whose files are located in dir. It returns an error describing every
+// database, retention policy or shard group in the backup that is missing or
+// differs on the target, and every backed up shard that does not exist in the
+// target's store. Databases present only on the target are ignored, and for a
+// limited backup only the databases included in the backup are compared.
+//
+// Shard contents are not compared, as the manifest does not record checksums.
+func VerifyRestore(c *snapshotter.Client, dir string, m *Manifest) error {
+	if m.Meta.FileName == "" {
+		return errors.New("manifest does not reference a meta store backup")
+	}
+
+	b, err := GetMetaBytes(filepath.Join(dir, m.Meta.FileName))
+	if err != nil {
+		return err
+	}
+
+	var src meta.Data
+	if err := src.UnmarshalBinary(b); err != nil {
+		return fmt.Errorf("unmarshal backup meta store: %s", err)
+	}
+
+	dst, err := c.MetastoreBackup()
+	if err != nil {
+		return fmt.Errorf("target meta store: %s", err)
+	}
+
+	diff, err := snapshotter.DiffMetastore(&src, dst)
+	if err != nil {
+		return err
+	}
+
+	// Only compare the databases included in a limited backup.
+	included := func(db string) bool { return true }
+	if m.Limited {
+		dbs := make(map[string]struct{})
+		if m.Database != "" {
+			dbs[m.Database] = struct{}{}
+		}
+		for _, f := range m.Files {
+			dbs[f.Database] = struct{}{}
+		}
+		included = func(db string) bool {
+			_, ok := dbs[db]
+			return ok
+		}
+	}
+
+	var problems []string
+	for _, db := range diff.RemovedDatabases {
+		if included(db) {
+			problems = append(problems, fmt.Sprintf("database %q missing", db))
+		}
+	}
+	for _, dbDiff := range diff.ChangedDatabases {
+		if included(dbDiff.Name) {
+			problems = append(problems, databaseDiffProblems(&dbDiff)...)
+		}
+	}
+
+	// The target's meta store is a copy of the backed up one after a restore,
+	// so the shards must be looked up in the target's store instead.
+	modified, err := c.ModifiedShards(context.Background(), time.Time{})
+	if err != nil {
+		return fmt.Errorf("target shards: %s", err)
+	}
+	shards := make(map[uint64]struct{}, len(modified))
+	for _, sh := range modified {
+		shards[sh.ID] = struct{}{}
+	}
+	for _, f := range m.Files {
+		if _, ok := shards[f.ShardID]; !ok {
+			problems = append(problems, fmt.Sprintf("shard %d (%s.%s) missing", f.ShardID, f.Database, f.Policy))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("restore verification failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// databaseDiffProblems describes the differences in d as a list of problems.
+func databaseDiffProblems(d *snapshotter.DatabaseDiff) []string {
+	var problems []string
+	if d.DefaultRetentionPolicyChanged {
+		problems = append(problems, fmt.Sprintf("database %q: default retention policy differs", d.Name))
+	}
+	for _, rp := range d.RemovedRetentionPolicies {
+		problems = append(problems, fmt.Sprintf("retention policy %q.%q missing", d.Name, rp))
+	}
+	for _, rp := range d.AddedRetentionPolicies {
+		problems = append(problems, fmt.Sprintf("retention policy %q.%q not in backup", d.Name, rp))
+	}
+	for _, rp := range d.ChangedRetentionPolicies {
+		if rp.SettingsChanged {
+			problems = append(problems, fmt.Sprintf("retention policy %q.%q: settings differ", d.Name, rp.Name))
+		}
+		for _, id := range rp.RemovedShardGroups {
+			problems = append(problems, fmt.Sprintf("retention policy %q.%q: shard group %d missing", d.Name, rp.Name, id))
+		}
+		for _, id := range rp.AddedShardGroups {
+			problems = append(problems, fmt.Sprintf("retention policy %q.%q: shard group %d not in backup", d.Name, rp.Name, id))
+		}
+		for _, id := range rp.ChangedShardGroups {
+			problems = append(problems, fmt.Sprintf("retention policy %q.%q: shard group %d differs", d.Name, rp.Name, id))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,169 @@
+package backup_util_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup_util"
+	"github.com/influxdata/influxdb/internal"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tcp"
+)
+
+var data = meta.Data{
+	Databases: []meta.DatabaseInfo{
+		{
+			Name:                   "db0",
+			DefaultRetentionPolicy: "rp0",
+			RetentionPolicies: []meta.RetentionPolicyInfo{
+				{
+					Name:               "rp0",
+					ReplicaN:           1,
+					ShardGroupDuration: 24 * time.Hour,
+					ShardGroups: []meta.ShardGroupInfo{
+						{
+							ID:        1,
+							StartTime: time.Unix(0, 0).UTC(),
+							EndTime:   time.Unix(0, 0).UTC().Add(24 * time.Hour),
+							Shards:    []meta.ShardInfo{{ID: 2}},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestVerifyRestore(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		manifest backup_util.Manifest
+		target   meta.Data
+		shards   []uint64
+		err      string
+	}{
+		{
+			name:     "match",
+			manifest: backup_util.Manifest{Files: []backup_util.Entry{{Database: "db0", Policy: "rp0", ShardID: 2}}},
+			target:   data,
+			shards:   []uint64{2},
+		},
+		{
+			name:     "missing database",
+			manifest: backup_util.Manifest{Files: []backup_util.Entry{{Database: "db0", Policy: "rp0", ShardID: 2}}},
+			target:   meta.Data{},
+			shards:   []uint64{2},
+			err:      `restore verification failed: database "db0" missing`,
+		},
+		{
+			name:     "missing retention policy",
+			manifest: backup_util.Manifest{Files: []backup_util.Entry{{Database: "db0", Policy: "rp0", ShardID: 2}}},
+			target: meta.Data{
+				Databases: []meta.DatabaseInfo{{Name: "db0", DefaultRetentionPolicy: "rp0"}},
+			},
+			shards: []uint64{2},
+			err:    `restore verification failed: retention policy "db0"."rp0" missing`,
+		},
+		{
+			// The meta store is restored, but the shard data is not.
+			name:     "missing shard",
+			manifest: backup_util.Manifest{Files: []backup_util.Entry{{Database: "db0", Policy: "rp0", ShardID: 2}}},
+			target:   data,
+			err:      `restore verification failed: shard 2 (db0.rp0) missing`,
+		},
+		{
+			name:     "limited backup without shards",
+			manifest: backup_util.Manifest{Limited: true, Database: "db0"},
+			target:   meta.Data{},
+			err:      `restore verification failed: database "db0" missing`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "backup_util")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			tt.manifest.Meta.FileName = "meta.00"
+			if err := WriteMetaFile(filepath.Join(dir, tt.manifest.Meta.FileName), &data); err != nil {
+				t.Fatal(err)
+			}
+
+			s, l, err := NewTestService(&tt.target, tt.shards)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			defer s.Close()
+
+			c := snapshotter.NewClient(l.Addr().String())
+			err = backup_util.VerifyRestore(c, dir, &tt.manifest)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: got=%v want=%s", err, tt.err)
+			}
+		})
+	}
+}
+
+// WriteMetaFile writes data to path in the format of a meta store backup.
+func WriteMetaFile(path string, data *meta.Data) error {
+	b, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var header [16]byte
+	binary.BigEndian.PutUint64(header[:8], snapshotter.BackupMagicHeader)
+	binary.BigEndian.PutUint64(header[8:16], uint64(len(b)))
+	return ioutil.WriteFile(path, append(header[:], b...), 0600)
+}
+
+// NewTestService returns an open snapshotter service serving data as its meta
+// store and shards as the shards in its store.
+func NewTestService(data *meta.Data, shards []uint64) (*snapshotter.Service, net.Listener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The snapshotter needs to be used with a tcp.Mux listener.
+	mux := tcp.NewMux()
+	go mux.Serve(l)
+
+	var store internal.TSDBStoreMock
+	store.ShardIDsFn = func() []uint64 { return shards }
+	store.ShardLastModifiedFn = func(id uint64) (time.Time, error) { return time.Time{}, nil }
+	store.ShardRelativePathFn = func(id uint64) (string, error) { return "", nil }
+
+	s := snapshotter.NewService()
+	s.Listener = mux.Listen(snapshotter.MuxHeader)
+	s.MetaClient = &MetaClient{Data: data}
+	s.TSDBStore = &store
+	if err := s.Open(); err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return s, l, nil
+}
+
+type MetaClient struct {
+	Data *meta.Data
+}
+
+func (m *MetaClient) MarshalBinary() ([]byte, error) {
+	return m.Data.MarshalBinary()
+}
+
+func (m *MetaClient) Database(name string) *meta.DatabaseInfo {
+	return m.Data.Database(name)
+}
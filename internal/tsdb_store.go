@@ -38,6 +38,7 @@ type TSDBStoreMock struct {
 	ShardFn                   func(id uint64) *tsdb.Shard
 	ShardGroupFn              func(ids []uint64) tsdb.ShardGroup
 	ShardIDsFn                func() []uint64
+	ShardLastModifiedFn       func(id uint64) (time.Time, error)
 	ShardNFn                  func() int
 	ShardRelativePathFn       func(id uint64) (string, error)
 	ShardsFn                  func(ids []uint64) []*tsdb.Shard
@@ -121,6 +122,9 @@ func (s *TSDBStoreMock) ShardGroup(ids []uint64) tsdb.ShardGroup {
 func (s *TSDBStoreMock) ShardIDs() []uint64 {
 	return s.ShardIDsFn()
 }
+func (s *TSDBStoreMock) ShardLastModified(id uint64) (time.Time, error) {
+	return s.ShardLastModifiedFn(id)
+}
 func (s *TSDBStoreMock) ShardN() int {
 	return s.ShardNFn()
 }
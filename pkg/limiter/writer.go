@@ -0,0 +1,115 @@
+package limiter
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Writer is an io.Writer that limits both the number of bytes and the number
+// of operations passed to the underlying writer per second.  Each call to Write
+// counts as a single operation.  A limit of zero or less disables that limit.
+type Writer struct {
+	w     io.Writer
+	bytes *bucket
+	ops   *bucket
+}
+
+// NewWriter returns a Writer that writes to w at no more than bytesPerSec bytes
+// and opsPerSec operations per second.
+func NewWriter(w io.Writer, bytesPerSec, opsPerSec int) *Writer {
+	return &Writer{
+		w:     w,
+		bytes: newBucket(bytesPerSec),
+		ops:   newBucket(opsPerSec),
+	}
+}
+
+// Write writes p to the underlying writer, blocking until both the byte and
+// operation limits have capacity.  Writes larger than one second's worth of
+// bytes are split into multiple writes to the underlying writer.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	ops := 1
+	for {
+		chunk := p
+		if w.bytes != nil && len(chunk) > int(w.bytes.burst) {
+			chunk = chunk[:int(w.bytes.burst)]
+		}
+		w.wait(len(chunk), ops)
+		ops = 0
+
+		nn, err := w.w.Write(chunk)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+
+		p = p[len(chunk):]
+		if len(p) == 0 {
+			return n, nil
+		}
+	}
+}
+
+// Op blocks until an operation can proceed without exceeding the operation
+// limit.  It is useful for accounting for operations that do not go through
+// Write, such as opening a new file.
+func (w *Writer) Op() {
+	w.wait(0, 1)
+}
+
+// wait blocks until n bytes and ops operations are available.
+func (w *Writer) wait(n, ops int) {
+	d := w.bytes.reserve(float64(n))
+	if od := w.ops.reserve(float64(ops)); od > d {
+		d = od
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// bucket is a token bucket that refills at rate tokens per second up to burst.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller must
+// wait before they are available.  A nil bucket never requires a wait.
+func (b *bucket) reserve(n float64) time.Duration {
+	if b == nil || n == 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
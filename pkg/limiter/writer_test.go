@@ -0,0 +1,63 @@
+package limiter_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/pkg/limiter"
+)
+
+func TestWriter_Write(t *testing.T) {
+	var buf bytes.Buffer
+	w := limiter.NewWriter(&buf, 0, 0)
+
+	if n, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if exp, got := 5, n; exp != got {
+		t.Fatalf("write count mismatch: exp %v, got %v", exp, got)
+	}
+
+	if exp, got := "hello", buf.String(); exp != got {
+		t.Fatalf("contents mismatch: exp %v, got %v", exp, got)
+	}
+}
+
+func TestWriter_Write_BytesLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := limiter.NewWriter(&buf, 100, 0)
+
+	// The first 100 bytes are available immediately, the next 50 take ~500ms.
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 150)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("write returned too quickly: %v", d)
+	}
+
+	if exp, got := 150, buf.Len(); exp != got {
+		t.Fatalf("length mismatch: exp %v, got %v", exp, got)
+	}
+}
+
+func TestWriter_Op(t *testing.T) {
+	var buf bytes.Buffer
+	w := limiter.NewWriter(&buf, 0, 10)
+
+	// The first 10 ops are available immediately, the next 5 take ~500ms.
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		w.Op()
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write([]byte("x")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("ops returned too quickly: %v", d)
+	}
+}
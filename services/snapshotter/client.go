@@ -2,19 +2,49 @@ package snapshotter
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/influxdata/influxdb/pkg/limiter"
 	"github.com/influxdata/influxdb/services/meta"
-	"github.com/influxdata/influxdb/tcp"
 )
 
 // Client provides an API for the snapshotter service.
 type Client struct {
 	host string
+
+	// Retry controls how requests that fail with a transient connection
+	// error are retried. The zero value disables retries.
+	Retry RetryPolicy
+
+	// TLSConfig, if set, is used to encrypt connections to the snapshotter
+	// service after the mux header. It is only for services embedded in other
+	// programs whose Listener is wrapped with tls.NewListener; influxd always
+	// serves the snapshotter in plaintext.
+	TLSConfig *tls.Config
+}
+
+// RetryPolicy controls how the client retries requests that fail with a
+// transient connection error, such as a dial failure or a connection reset.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is attempted.
+	// Values less than two disable retries.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It doubles on each
+	// subsequent retry.
+	Backoff time.Duration
 }
 
 // NewClient returns a new *Client.
@@ -24,57 +54,394 @@ func NewClient(host string) *Client {
 
 // MetastoreBackup returns a snapshot of the meta store.
 func (c *Client) MetastoreBackup() (*meta.Data, error) {
+	return c.MetastoreBackupWithContext(context.Background())
+}
+
+// MetastoreBackupWithContext returns a snapshot of the meta store. If ctx is
+// cancelled before the snapshot is received, the connection is closed and
+// ctx.Err() is returned.
+func (c *Client) MetastoreBackupWithContext(ctx context.Context) (*meta.Data, error) {
+	var data meta.Data
+	err := c.withRetry(ctx, func() (bool, error) {
+		return false, c.readMetastoreBackup(ctx, func(r io.Reader, length int64) error {
+			// Grow the buffer as bytes arrive rather than trusting the length
+			// sent by the server for the allocation.
+			var buf bytes.Buffer
+			if _, err := io.CopyN(&buf, r, length); err == io.EOF {
+				return io.ErrUnexpectedEOF
+			} else if err != nil {
+				return err
+			}
+
+			// Unpack meta data.
+			if err := data.UnmarshalBinary(buf.Bytes()); err != nil {
+				return fmt.Errorf("unmarshal: %s", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// MetastoreBackupTo streams a snapshot of the meta store to w and returns the
+// number of bytes written. Unlike MetastoreBackup, the snapshot is never held
+// in memory, so peak memory use does not depend on the size of the meta store.
+// The bytes written can be unpacked with meta.Data.UnmarshalBinary.
+func (c *Client) MetastoreBackupTo(ctx context.Context, w io.Writer) (int64, error) {
+	var n int64
+	err := c.withRetry(ctx, func() (bool, error) {
+		err := c.readMetastoreBackup(ctx, func(r io.Reader, length int64) error {
+			var err error
+			n, err = io.CopyN(w, r, length)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		})
+		return n > 0, err
+	})
+	return n, err
+}
+
+// readMetastoreBackup requests a meta store backup, validates the header and
+// calls fn with the connection positioned at the start of the meta store bytes.
+func (c *Client) readMetastoreBackup(ctx context.Context, fn func(r io.Reader, length int64) error) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
 	req := &Request{
 		Type: RequestMetastoreBackup,
 	}
+	if err := writeRequest(conn, req); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
 
-	b, err := c.doRequest(req)
-	if err != nil {
-		return nil, err
+	// Read the magic and the size of the meta store bytes.
+	var header [16]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
 	}
 
 	// Check the magic.
-	magic := binary.BigEndian.Uint64(b[:8])
-	if magic != BackupMagicHeader {
-		return nil, errors.New("invalid metadata received")
+	if magic := binary.BigEndian.Uint64(header[:8]); magic != BackupMagicHeader {
+		return errors.New("invalid metadata received")
+	}
+	length := int64(binary.BigEndian.Uint64(header[8:16]))
+	if length < 0 {
+		return errors.New("invalid metadata length received")
 	}
-	i := 8
 
-	// Size of the meta store bytes.
-	length := int(binary.BigEndian.Uint64(b[i : i+8]))
-	i += 8
-	metaBytes := b[i : i+length]
+	if err := fn(conn, length); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
 
-	// Unpack meta data.
-	var data meta.Data
-	if err := data.UnmarshalBinary(metaBytes); err != nil {
-		return nil, fmt.Errorf("unmarshal: %s", err)
+// BackupShards downloads the backups of shardIDs, running up to concurrency
+// downloads at a time. The backup of each shard is written to the writer
+// returned by w for that shard. w is called from the download goroutines, so
+// it must be safe for concurrent use.
+//
+// A failed download does not stop the others; all errors are aggregated into
+// the returned error. If the writer of a failed shard implements
+// Truncate(int64) error, such as *os.File, it is truncated so that no partial
+// backup is left behind. Cancelling ctx aborts all in-progress downloads.
+//
+// The protocol cannot signal a server side failure once the backup of a shard
+// has started: the service closes the connection the same way whether the
+// backup completed or not. Such a truncated backup is reported as a success;
+// only a backup for which no data was received is reported as failed.
+func (c *Client) BackupShards(ctx context.Context, shardIDs []uint64, w func(shardID uint64) io.Writer, concurrency int) error {
+	_, err := c.backupShards(ctx, shardIDs, time.Time{}, w, concurrency)
+	return err
+}
+
+// IncrementalBackup backs up the shards that were modified after since,
+// running up to concurrency downloads at a time. The backup of each shard only
+// contains the files modified after since and is written to the writer
+// returned by w for that shard. If since is zero, all shards are backed up in
+// full. Failed downloads are handled as described for BackupShards.
+//
+// The returned shards are those that were backed up successfully, even if an
+// error is also returned. They include the time each was last modified, so the
+// next incremental backup can be chained from the latest of them, but only if
+// err is nil. After an error, the next backup must reuse the same since, or
+// the changes to the shards that failed would never be backed up.
+func (c *Client) IncrementalBackup(ctx context.Context, since time.Time, w func(shardID uint64) io.Writer, concurrency int) ([]ModifiedShard, error) {
+	shards, err := c.ModifiedShards(ctx, since)
+	if err != nil {
+		return nil, err
 	}
 
-	return &data, nil
+	ids := make([]uint64, len(shards))
+	for i := range shards {
+		ids[i] = shards[i].ID
+	}
+
+	done, err := c.backupShards(ctx, ids, since, w, concurrency)
+
+	var backedUp []ModifiedShard
+	for _, sh := range shards {
+		if done[sh.ID] {
+			backedUp = append(backedUp, sh)
+		}
+	}
+	return backedUp, err
 }
 
-// doRequest sends a request to the snapshotter service and returns the result.
-func (c *Client) doRequest(req *Request) ([]byte, error) {
-	// Connect to snapshotter service.
-	conn, err := tcp.Dial("tcp", c.host, MuxHeader)
+// ModifiedShards returns the shards on the server that were modified after
+// since. All shards are returned if since is zero.
+func (c *Client) ModifiedShards(ctx context.Context, since time.Time) ([]ModifiedShard, error) {
+	req := &Request{
+		Type:  RequestModifiedShards,
+		Since: since,
+	}
+
+	var resp Response
+	err := c.withRetry(ctx, func() (bool, error) {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		defer closeOnDone(ctx, conn)()
+
+		if err := writeRequest(conn, req); err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			return false, err
+		}
+
+		if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			} else if isTransient(err) {
+				// Returned as is so that connection resets can be retried.
+				return false, err
+			}
+			return false, fmt.Errorf("decode response: %s", err)
+		}
+		return false, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	return resp.Shards, nil
+}
+
+// backupShards downloads the backups of shardIDs since the given time and
+// returns the set of shards that were backed up successfully.
+// See BackupShards for details.
+func (c *Client) backupShards(ctx context.Context, shardIDs []uint64, since time.Time, w func(shardID uint64) io.Writer, concurrency int) (map[uint64]bool, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []string
+		done  = make(map[uint64]bool)
+		limit = limiter.NewFixed(concurrency)
+	)
+	for _, id := range shardIDs {
+		limit.Take()
+		if ctx.Err() != nil {
+			limit.Release()
+			break
+		}
+
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			defer limit.Release()
+
+			sw := w(id)
+			err := c.backupShard(ctx, id, since, sw)
+			if err == nil {
+				mu.Lock()
+				done[id] = true
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			errs = append(errs, fmt.Sprintf("shard %d: %s", id, err))
+			mu.Unlock()
+
+			if t, ok := sw.(interface {
+				Truncate(size int64) error
+			}); ok {
+				if err := t.Truncate(0); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("shard %d: truncate: %s", id, err))
+					mu.Unlock()
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return done, err
+	} else if len(errs) > 0 {
+		return done, fmt.Errorf("backup shards: %s", strings.Join(errs, "; "))
+	}
+	return done, nil
+}
+
+// backupShard streams the backup of a single shard since the given time to w.
+// The request is only retried if nothing has been written to w yet.
+func (c *Client) backupShard(ctx context.Context, id uint64, since time.Time, w io.Writer) error {
+	req := &Request{
+		Type:    RequestShardBackup,
+		ShardID: id,
+		Since:   since,
+	}
+
+	return c.withRetry(ctx, func() (bool, error) {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		defer closeOnDone(ctx, conn)()
 
-	// Write the request
-	_, err = conn.Write([]byte{byte(req.Type)})
+		if err := writeRequest(conn, req); err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			return false, err
+		}
+
+		n, err := io.Copy(w, conn)
+		if err != nil && ctx.Err() != nil {
+			return n > 0, ctx.Err()
+		} else if err == nil && n == 0 {
+			// Even an empty shard backup contains a tar footer, so nothing
+			// being written means the backup failed before it started, e.g.
+			// because the shard doesn't exist. Failures after data was sent
+			// also end with a clean EOF and cannot be told apart from success.
+			return false, errors.New("no data received")
+		}
+		return n > 0, err
+	})
+}
+
+// dial connects to the snapshotter service.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.host)
 	if err != nil {
 		return nil, err
 	}
-	if err := json.NewEncoder(conn).Encode(req); err != nil {
-		return nil, fmt.Errorf("encode snapshot request: %s", err)
+
+	// The error is returned as is so that connection resets can be retried.
+	if _, err := conn.Write([]byte{MuxHeader}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The mux header is always sent in plaintext so the connection can be
+	// routed before the TLS handshake.
+	if c.TLSConfig != nil {
+		config := c.TLSConfig
+		if config.ServerName == "" && !config.InsecureSkipVerify {
+			config = config.Clone()
+			if host, _, err := net.SplitHostPort(c.host); err == nil {
+				config.ServerName = host
+			}
+		}
+		return tls.Client(conn, config), nil
 	}
+	return conn, nil
+}
 
-	// Read snapshot from the connection
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, conn)
+// closeOnDone closes conn when ctx is done, unblocking any pending reads or
+// writes. The returned function stops the watch and must be called once the
+// request completes.
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
 
-	return buf.Bytes(), err
+// writeRequest writes the request type followed by the encoded request to conn.
+// Write errors are returned as is so that connection resets can be retried.
+func writeRequest(conn net.Conn, req *Request) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("encode snapshot request: %s", err)
+	}
+
+	buf := make([]byte, 0, len(b)+2)
+	buf = append(buf, byte(req.Type))
+	buf = append(buf, b...)
+	buf = append(buf, '\n')
+	_, err = conn.Write(buf)
+	return err
+}
+
+// withRetry calls fn until it succeeds or the retry policy is exhausted. Only
+// transient connection errors are retried. fn reports whether it already
+// passed data on to the caller, in which case the request cannot be safely
+// restarted and its error is returned as is.
+func (c *Client) withRetry(ctx context.Context, fn func() (started bool, err error)) error {
+	backoff := c.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		started, err := fn()
+		if err == nil || started || !isTransient(err) || attempt >= c.Retry.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient returns true if err is a connection error that may not occur
+// again if the request is retried.
+func isTransient(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *net.OpError:
+			if e.Op == "dial" {
+				return true
+			}
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err == syscall.ECONNRESET || err == syscall.EPIPE || err == io.ErrUnexpectedEOF
+		}
+	}
 }
@@ -2,13 +2,29 @@ package snapshotter_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/davecgh/go-spew/spew"
 	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/internal"
+	"github.com/influxdata/influxdb/services/meta"
 	"github.com/influxdata/influxdb/services/snapshotter"
 )
 
@@ -87,3 +103,468 @@ func TestClient_MetastoreBackup_InvalidMetadata(t *testing.T) {
 		t.Errorf("timeout while waiting for the goroutine")
 	}
 }
+
+func TestClient_MetastoreBackup_InvalidLength(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		length uint64
+		err    string
+	}{
+		{name: "negative", length: 1 << 63, err: "invalid metadata length received"},
+		{name: "truncated", length: 1 << 40, err: io.ErrUnexpectedEOF.Error()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var numBytes [16]byte
+			binary.BigEndian.PutUint64(numBytes[:8], snapshotter.BackupMagicHeader)
+			binary.BigEndian.PutUint64(numBytes[8:16], tt.length)
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer l.Close()
+
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+
+					// Read the mux header, request type and request.
+					var header [2]byte
+					io.ReadFull(conn, header[:])
+					var m map[string]interface{}
+					json.NewDecoder(conn).Decode(&m)
+
+					// Write the header followed by fewer bytes than claimed.
+					conn.Write(numBytes[:])
+					conn.Write([]byte("meta"))
+					conn.Close()
+				}
+			}()
+
+			c := snapshotter.NewClient(l.Addr().String())
+			if _, err := c.MetastoreBackup(); err == nil || err.Error() != tt.err {
+				t.Errorf("unexpected MetastoreBackup error: got=%v want=%q", err, tt.err)
+			}
+			if _, err := c.MetastoreBackupTo(context.Background(), ioutil.Discard); err == nil || err.Error() != tt.err {
+				t.Errorf("unexpected MetastoreBackupTo error: got=%v want=%q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestClient_BackupShards(t *testing.T) {
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var tsdb internal.TSDBStoreMock
+	tsdb.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		fmt.Fprintf(w, "shard-%d", id)
+		return nil
+	}
+	s.TSDBStore = &tsdb
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+
+	bufs := NewShardBuffers()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	if err := c.BackupShards(context.Background(), []uint64{1, 2, 3}, bufs.Writer, 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, id := range []uint64{1, 2, 3} {
+		if got, want := bufs.String(id), fmt.Sprintf("shard-%d", id); got != want {
+			t.Errorf("unexpected shard data: got=%q want=%q", got, want)
+		}
+	}
+}
+
+func TestClient_BackupShards_Canceled(t *testing.T) {
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	release := make(chan struct{})
+
+	var tsdb internal.TSDBStoreMock
+	tsdb.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		<-release
+		return nil
+	}
+	s.TSDBStore = &tsdb
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	w := func(id uint64) io.Writer { return ioutil.Discard }
+	if err := c.BackupShards(ctx, []uint64{1, 2}, w, 1); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: got=%v want=%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClient_MetastoreBackupWithContext_Canceled(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	// Accept the connection but never respond.
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ioutil.ReadAll(conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	if _, err := c.MetastoreBackupWithContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: got=%v want=%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClient_MetastoreBackup_Retry(t *testing.T) {
+	metaBlob, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var numBytes [16]byte
+	binary.BigEndian.PutUint64(numBytes[:8], snapshotter.BackupMagicHeader)
+	binary.BigEndian.PutUint64(numBytes[8:16], uint64(len(metaBlob)))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			// Read the mux header, request type and request.
+			var header [2]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				t.Errorf("unable to read headers: %s", err)
+			}
+			var m map[string]interface{}
+			if err := json.NewDecoder(conn).Decode(&m); err != nil {
+				t.Errorf("invalid json request: %s", err)
+			}
+
+			// Reset the first connection, and respond on the second.
+			if i == 0 {
+				conn.(*net.TCPConn).SetLinger(0)
+			} else {
+				conn.Write(numBytes[:])
+				conn.Write(metaBlob)
+			}
+			conn.Close()
+		}
+	}()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	c.Retry = snapshotter.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	if _, err := c.MetastoreBackup(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestClient_ModifiedShards_Retry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			// Read the mux header, request type and request.
+			var header [2]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				t.Errorf("unable to read headers: %s", err)
+			}
+			var m map[string]interface{}
+			if err := json.NewDecoder(conn).Decode(&m); err != nil {
+				t.Errorf("invalid json request: %s", err)
+			}
+
+			// Reset the first connection while the response is read, and
+			// respond on the second.
+			if i == 0 {
+				conn.Write([]byte(`{"Shards":[`))
+				conn.(*net.TCPConn).SetLinger(0)
+			} else {
+				json.NewEncoder(conn).Encode(snapshotter.Response{
+					Shards: []snapshotter.ModifiedShard{{ID: 1, Path: "db0/rp0/1"}},
+				})
+			}
+			conn.Close()
+		}
+	}()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	c.Retry = snapshotter.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	shards, err := c.ModifiedShards(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if len(shards) != 1 || shards[0].ID != 1 {
+		t.Fatalf("unexpected shards: %s", spew.Sdump(shards))
+	}
+}
+
+func TestClient_MetastoreBackupTo(t *testing.T) {
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s.MetaClient = &MetaClient{Data: data}
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+
+	var buf bytes.Buffer
+	c := snapshotter.NewClient(l.Addr().String())
+	n, err := c.MetastoreBackupTo(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("unable to stream metastore backup: %s", err)
+	} else if got, want := n, int64(buf.Len()); got != want {
+		t.Fatalf("unexpected byte count: got=%d want=%d", got, want)
+	}
+
+	var got meta.Data
+	if err := got.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatalf("unexpected unmarshal error: %s", err)
+	} else if want := data; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected data backup:\n\ngot=%s\nwant=%s", spew.Sdump(got), spew.Sdump(want))
+	}
+}
+
+func TestClient_MetastoreBackup_TLS(t *testing.T) {
+	cert, pool := NewTestCertificate(t)
+
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s.MetaClient = &MetaClient{Data: data}
+	s.Listener = tls.NewListener(s.Listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	c.TLSConfig = &tls.Config{RootCAs: pool}
+	if got, err := c.MetastoreBackup(); err != nil {
+		t.Fatalf("unable to obtain metastore backup: %s", err)
+	} else if want := &data; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected data backup:\n\ngot=%s\nwant=%s", spew.Sdump(got), spew.Sdump(want))
+	}
+}
+
+// NewTestCertificate returns a self-signed certificate for 127.0.0.1 and a
+// certificate pool that trusts it.
+func NewTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"InfluxDB"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestClient_IncrementalBackup(t *testing.T) {
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	since := time.Unix(100, 0).UTC()
+	lastModified := map[uint64]time.Time{
+		1: since.Add(time.Second),
+		2: since.Add(-time.Second),
+		3: since.Add(time.Second),
+	}
+
+	var tsdbStore internal.TSDBStoreMock
+	tsdbStore.ShardIDsFn = func() []uint64 { return []uint64{1, 2, 3} }
+	tsdbStore.ShardLastModifiedFn = func(id uint64) (time.Time, error) {
+		return lastModified[id], nil
+	}
+	tsdbStore.ShardRelativePathFn = func(id uint64) (string, error) {
+		return fmt.Sprintf("db0/rp0/%d", id), nil
+	}
+	tsdbStore.BackupShardFn = func(id uint64, got time.Time, w io.Writer) error {
+		if !got.Equal(since) {
+			t.Errorf("unexpected since for shard %d: got=%s want=%s", id, got, since)
+		}
+		if id == 3 {
+			return fmt.Errorf("shard %d doesn't exist on this server", id)
+		}
+		fmt.Fprintf(w, "shard-%d", id)
+		return nil
+	}
+	s.TSDBStore = &tsdbStore
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+
+	bufs := NewShardBuffers()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	shards, err := c.IncrementalBackup(context.Background(), since, bufs.Writer, 2)
+	if got, want := fmt.Sprint(err), "backup shards: shard 3: no data received"; got != want {
+		t.Fatalf("unexpected error: got=%q want=%q", got, want)
+	}
+
+	// Only shard 1 was both modified after since and backed up successfully.
+	if len(shards) != 1 {
+		t.Fatalf("unexpected shards: %s", spew.Sdump(shards))
+	} else if sh := shards[0]; sh.ID != 1 || sh.Path != "db0/rp0/1" || !sh.LastModified.Equal(lastModified[1]) {
+		t.Fatalf("unexpected shard: %s", spew.Sdump(sh))
+	}
+
+	if got, want := bufs.String(1), "shard-1"; got != want {
+		t.Errorf("unexpected shard data: got=%q want=%q", got, want)
+	}
+	if bufs.Requested(2) {
+		t.Errorf("unexpected backup of unmodified shard 2")
+	}
+}
+
+func TestClient_BackupShards_ServerError(t *testing.T) {
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var tsdb internal.TSDBStoreMock
+	tsdb.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		if id == 2 {
+			return fmt.Errorf("shard %d doesn't exist on this server", id)
+		}
+		fmt.Fprintf(w, "shard-%d", id)
+		return nil
+	}
+	s.TSDBStore = &tsdb
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+
+	bufs := NewShardBuffers()
+
+	c := snapshotter.NewClient(l.Addr().String())
+	err = c.BackupShards(context.Background(), []uint64{1, 2}, bufs.Writer, 2)
+	if got, want := fmt.Sprint(err), "backup shards: shard 2: no data received"; got != want {
+		t.Fatalf("unexpected error: got=%q want=%q", got, want)
+	}
+
+	if got, want := bufs.String(1), "shard-1"; got != want {
+		t.Errorf("unexpected shard data: got=%q want=%q", got, want)
+	}
+}
+
+// ShardBuffers holds the shard backups written by the client in memory.
+type ShardBuffers struct {
+	mu   sync.Mutex
+	bufs map[uint64]*bytes.Buffer
+}
+
+// NewShardBuffers returns a new instance of ShardBuffers.
+func NewShardBuffers() *ShardBuffers {
+	return &ShardBuffers{bufs: make(map[uint64]*bytes.Buffer)}
+}
+
+// Writer returns a new buffer for the backup of a shard. It is safe to call
+// from the client's concurrent downloads.
+func (b *ShardBuffers) Writer(id uint64) io.Writer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bufs[id] = &bytes.Buffer{}
+	return b.bufs[id]
+}
+
+// Requested returns true if a writer was requested for the shard.
+func (b *ShardBuffers) Requested(id uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.bufs[id]
+	return ok
+}
+
+// String returns the backup written for the shard.
+func (b *ShardBuffers) String(id uint64) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if buf := b.bufs[id]; buf != nil {
+		return buf.String()
+	}
+	return ""
+}
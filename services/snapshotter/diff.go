@@ -0,0 +1,176 @@
+package snapshotter
+
+import (
+	"errors"
+
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+// MetaDiff describes the differences between two meta stores.
+type MetaDiff struct {
+	AddedDatabases   []string
+	RemovedDatabases []string
+	ChangedDatabases []DatabaseDiff
+}
+
+// Empty returns true if the meta stores are equivalent.
+func (d *MetaDiff) Empty() bool {
+	return len(d.AddedDatabases) == 0 && len(d.RemovedDatabases) == 0 && len(d.ChangedDatabases) == 0
+}
+
+// DatabaseDiff describes the differences of a database present in both meta stores.
+type DatabaseDiff struct {
+	Name                          string
+	DefaultRetentionPolicyChanged bool
+
+	AddedRetentionPolicies   []string
+	RemovedRetentionPolicies []string
+	ChangedRetentionPolicies []RetentionPolicyDiff
+}
+
+// RetentionPolicyDiff describes the differences of a retention policy present
+// in both meta stores.
+type RetentionPolicyDiff struct {
+	Name string
+
+	// SettingsChanged is true if the duration, shard group duration or
+	// replication factor differ.
+	SettingsChanged bool
+
+	AddedShardGroups   []uint64
+	RemovedShardGroups []uint64
+	ChangedShardGroups []uint64
+}
+
+// DiffMetastore returns the databases, retention policies and shard groups that
+// were added, removed or changed going from a to b.
+func DiffMetastore(a, b *meta.Data) (*MetaDiff, error) {
+	if a == nil || b == nil {
+		return nil, errors.New("cannot diff nil meta data")
+	}
+
+	diff := &MetaDiff{}
+	for _, adb := range a.Databases {
+		bdb := b.Database(adb.Name)
+		if bdb == nil {
+			diff.RemovedDatabases = append(diff.RemovedDatabases, adb.Name)
+			continue
+		}
+
+		if dbDiff := diffDatabase(&adb, bdb); dbDiff != nil {
+			diff.ChangedDatabases = append(diff.ChangedDatabases, *dbDiff)
+		}
+	}
+
+	for _, bdb := range b.Databases {
+		if a.Database(bdb.Name) == nil {
+			diff.AddedDatabases = append(diff.AddedDatabases, bdb.Name)
+		}
+	}
+
+	return diff, nil
+}
+
+// diffDatabase returns the differences between a and b, or nil if there are none.
+func diffDatabase(a, b *meta.DatabaseInfo) *DatabaseDiff {
+	diff := &DatabaseDiff{
+		Name:                          a.Name,
+		DefaultRetentionPolicyChanged: a.DefaultRetentionPolicy != b.DefaultRetentionPolicy,
+	}
+
+	for _, arp := range a.RetentionPolicies {
+		brp := retentionPolicy(b, arp.Name)
+		if brp == nil {
+			diff.RemovedRetentionPolicies = append(diff.RemovedRetentionPolicies, arp.Name)
+			continue
+		}
+
+		if rpDiff := diffRetentionPolicy(&arp, brp); rpDiff != nil {
+			diff.ChangedRetentionPolicies = append(diff.ChangedRetentionPolicies, *rpDiff)
+		}
+	}
+
+	for _, brp := range b.RetentionPolicies {
+		if retentionPolicy(a, brp.Name) == nil {
+			diff.AddedRetentionPolicies = append(diff.AddedRetentionPolicies, brp.Name)
+		}
+	}
+
+	if !diff.DefaultRetentionPolicyChanged && len(diff.AddedRetentionPolicies) == 0 &&
+		len(diff.RemovedRetentionPolicies) == 0 && len(diff.ChangedRetentionPolicies) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// diffRetentionPolicy returns the differences between a and b, or nil if there are none.
+func diffRetentionPolicy(a, b *meta.RetentionPolicyInfo) *RetentionPolicyDiff {
+	diff := &RetentionPolicyDiff{
+		Name: a.Name,
+		SettingsChanged: a.Duration != b.Duration ||
+			a.ShardGroupDuration != b.ShardGroupDuration ||
+			a.ReplicaN != b.ReplicaN,
+	}
+
+	for i := range a.ShardGroups {
+		asg := &a.ShardGroups[i]
+		bsg := shardGroup(b, asg.ID)
+		if bsg == nil {
+			diff.RemovedShardGroups = append(diff.RemovedShardGroups, asg.ID)
+		} else if !shardGroupsEqual(asg, bsg) {
+			diff.ChangedShardGroups = append(diff.ChangedShardGroups, asg.ID)
+		}
+	}
+
+	for i := range b.ShardGroups {
+		if shardGroup(a, b.ShardGroups[i].ID) == nil {
+			diff.AddedShardGroups = append(diff.AddedShardGroups, b.ShardGroups[i].ID)
+		}
+	}
+
+	if !diff.SettingsChanged && len(diff.AddedShardGroups) == 0 &&
+		len(diff.RemovedShardGroups) == 0 && len(diff.ChangedShardGroups) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// retentionPolicy returns the retention policy with the given name, or nil.
+// Unlike DatabaseInfo.RetentionPolicy, an empty name is not resolved to the default.
+func retentionPolicy(db *meta.DatabaseInfo, name string) *meta.RetentionPolicyInfo {
+	for i := range db.RetentionPolicies {
+		if db.RetentionPolicies[i].Name == name {
+			return &db.RetentionPolicies[i]
+		}
+	}
+	return nil
+}
+
+// shardGroup returns the shard group with the given id, or nil.
+func shardGroup(rp *meta.RetentionPolicyInfo, id uint64) *meta.ShardGroupInfo {
+	for i := range rp.ShardGroups {
+		if rp.ShardGroups[i].ID == id {
+			return &rp.ShardGroups[i]
+		}
+	}
+	return nil
+}
+
+// shardGroupsEqual returns true if a and b cover the same time range, have the
+// same deletion state and contain the same shards.
+func shardGroupsEqual(a, b *meta.ShardGroupInfo) bool {
+	if !a.StartTime.Equal(b.StartTime) || !a.EndTime.Equal(b.EndTime) ||
+		!a.DeletedAt.Equal(b.DeletedAt) || !a.TruncatedAt.Equal(b.TruncatedAt) {
+		return false
+	}
+
+	if len(a.Shards) != len(b.Shards) {
+		return false
+	}
+	for i := range a.Shards {
+		if a.Shards[i].ID != b.Shards[i].ID {
+			return false
+		}
+	}
+	return true
+}
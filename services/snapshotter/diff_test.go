@@ -0,0 +1,91 @@
+package snapshotter_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/services/snapshotter"
+)
+
+func TestDiffMetastore(t *testing.T) {
+	a := &meta.Data{
+		Databases: []meta.DatabaseInfo{
+			{
+				Name:                   "db0",
+				DefaultRetentionPolicy: "autogen",
+				RetentionPolicies: []meta.RetentionPolicyInfo{
+					{
+						Name:     "autogen",
+						ReplicaN: 1,
+						ShardGroups: []meta.ShardGroupInfo{
+							{ID: 1, StartTime: time.Unix(0, 0), EndTime: time.Unix(10, 0), Shards: []meta.ShardInfo{{ID: 1}}},
+							{ID: 2, StartTime: time.Unix(10, 0), EndTime: time.Unix(20, 0), Shards: []meta.ShardInfo{{ID: 2}}},
+						},
+					},
+					{Name: "rp0", ReplicaN: 1},
+				},
+			},
+			{Name: "db1"},
+		},
+	}
+	b := &meta.Data{
+		Databases: []meta.DatabaseInfo{
+			{
+				Name:                   "db0",
+				DefaultRetentionPolicy: "autogen",
+				RetentionPolicies: []meta.RetentionPolicyInfo{
+					{
+						Name:     "autogen",
+						ReplicaN: 1,
+						ShardGroups: []meta.ShardGroupInfo{
+							{ID: 2, StartTime: time.Unix(10, 0), EndTime: time.Unix(20, 0), Shards: []meta.ShardInfo{{ID: 3}}},
+							{ID: 4, StartTime: time.Unix(20, 0), EndTime: time.Unix(30, 0), Shards: []meta.ShardInfo{{ID: 4}}},
+						},
+					},
+					{Name: "rp1", ReplicaN: 1},
+				},
+			},
+			{Name: "db2"},
+		},
+	}
+
+	diff, err := snapshotter.DiffMetastore(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := &snapshotter.MetaDiff{
+		AddedDatabases:   []string{"db2"},
+		RemovedDatabases: []string{"db1"},
+		ChangedDatabases: []snapshotter.DatabaseDiff{
+			{
+				Name:                     "db0",
+				AddedRetentionPolicies:   []string{"rp1"},
+				RemovedRetentionPolicies: []string{"rp0"},
+				ChangedRetentionPolicies: []snapshotter.RetentionPolicyDiff{
+					{
+						Name:               "autogen",
+						AddedShardGroups:   []uint64{4},
+						RemovedShardGroups: []uint64{1},
+						ChangedShardGroups: []uint64{2},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(diff, exp) {
+		t.Errorf("unexpected diff:\n\ngot=%s\nwant=%s", spew.Sdump(diff), spew.Sdump(exp))
+	}
+}
+
+func TestDiffMetastore_Equal(t *testing.T) {
+	diff, err := snapshotter.DiffMetastore(&data, &data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !diff.Empty() {
+		t.Errorf("expected empty diff, got: %s", spew.Sdump(diff))
+	}
+}
@@ -43,6 +43,8 @@ type Service struct {
 		BackupShard(id uint64, since time.Time, w io.Writer) error
 		ExportShard(id uint64, ExportStart time.Time, ExportEnd time.Time, w io.Writer) error
 		Shard(id uint64) *tsdb.Shard
+		ShardIDs() []uint64
+		ShardLastModified(id uint64) (time.Time, error)
 		ShardRelativePath(id uint64) (string, error)
 	}
 
@@ -141,6 +143,8 @@ func (s *Service) handleConn(conn net.Conn) error {
 		return s.writeDatabaseInfo(conn, r.BackupDatabase)
 	case RequestRetentionPolicyInfo:
 		return s.writeRetentionPolicyInfo(conn, r.BackupDatabase, r.BackupRetentionPolicy)
+	case RequestModifiedShards:
+		return s.writeModifiedShards(conn, r.Since)
 	default:
 		return fmt.Errorf("request type unknown: %v", r.Type)
 	}
@@ -273,6 +277,42 @@ func (s *Service) writeRetentionPolicyInfo(conn net.Conn, database, retentionPol
 	return nil
 }
 
+// writeModifiedShards will write the shards on this server that were modified
+// after since into the connection. All shards are written if since is zero.
+// Shards whose last modified time is unknown, e.g. because their engine is not
+// open, are always written so that an incremental backup never misses changes.
+func (s *Service) writeModifiedShards(conn net.Conn, since time.Time) error {
+	res := Response{}
+	for _, id := range s.TSDBStore.ShardIDs() {
+		// Errors mean the shard was deleted after listing the shard ids.
+		lastModified, err := s.TSDBStore.ShardLastModified(id)
+		if err != nil {
+			continue
+		}
+
+		if !since.IsZero() && !lastModified.IsZero() && !lastModified.After(since) {
+			continue
+		}
+
+		path, err := s.TSDBStore.ShardRelativePath(id)
+		if err != nil {
+			continue
+		}
+
+		res.Shards = append(res.Shards, ModifiedShard{
+			ID:           id,
+			Path:         path,
+			LastModified: lastModified,
+		})
+	}
+
+	if err := json.NewEncoder(conn).Encode(res); err != nil {
+		return fmt.Errorf("encode response: %s", err.Error())
+	}
+
+	return nil
+}
+
 // readRequest unmarshals a request object from the conn.
 func (s *Service) readRequest(conn net.Conn) (Request, error) {
 	var r Request
@@ -309,6 +349,10 @@ const (
 	// RequestShardUpdate will initiate the upload of a shard data tar file
 	// and have the engine import the data.
 	RequestShardUpdate
+
+	// RequestModifiedShards represents a request for the shards on this server
+	// that were modified after the request's Since time.
+	RequestModifiedShards
 )
 
 // Request represents a request for a specific backup or for information
@@ -327,7 +371,16 @@ type Request struct {
 }
 
 // Response contains the relative paths for all the shards on this server
-// that are in the requested database or retention policy.
+// that are in the requested database or retention policy, or the shards
+// that were modified since the requested time.
 type Response struct {
-	Paths []string
+	Paths  []string
+	Shards []ModifiedShard `json:",omitempty"`
+}
+
+// ModifiedShard describes a shard on this server and when it was last modified.
+type ModifiedShard struct {
+	ID           uint64
+	Path         string
+	LastModified time.Time
 }
@@ -378,6 +378,85 @@ func TestSnapshotter_RequestRetentionPolicyInfo(t *testing.T) {
 	}
 }
 
+func TestSnapshotter_RequestModifiedShards(t *testing.T) {
+	s, l, err := NewTestService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	since := time.Unix(100, 0).UTC()
+	lastModified := map[uint64]time.Time{
+		1: since.Add(time.Second),
+		2: since.Add(-time.Second),
+		3: {}, // engine not open
+	}
+
+	var tsdbStore internal.TSDBStoreMock
+	tsdbStore.ShardIDsFn = func() []uint64 { return []uint64{1, 2, 3, 4, 5} }
+	tsdbStore.ShardLastModifiedFn = func(id uint64) (time.Time, error) {
+		if id == 4 {
+			return time.Time{}, fmt.Errorf("shard %d doesn't exist on this server", id)
+		}
+		return lastModified[id], nil
+	}
+	tsdbStore.ShardRelativePathFn = func(id uint64) (string, error) {
+		if id == 5 {
+			return "", fmt.Errorf("shard %d doesn't exist on this server", id)
+		}
+		return fmt.Sprintf("db0/rp0/%d", id), nil
+	}
+
+	s.TSDBStore = &tsdbStore
+	if err := s.Open(); err != nil {
+		t.Fatalf("unexpected open error: %s", err)
+	}
+	defer s.Close()
+
+	for _, tt := range []struct {
+		since time.Time
+		want  []uint64
+	}{
+		{since: time.Time{}, want: []uint64{1, 2, 3}},
+		{since: since, want: []uint64{1, 3}},
+	} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		req := snapshotter.Request{
+			Type:  snapshotter.RequestModifiedShards,
+			Since: tt.since,
+		}
+		conn.Write([]byte{snapshotter.MuxHeader})
+		conn.Write([]byte{byte(req.Type)})
+		if err := json.NewEncoder(conn).Encode(&req); err != nil {
+			t.Fatalf("unable to encode request: %s", err)
+		}
+
+		var resp snapshotter.Response
+		if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+			t.Fatalf("error decoding response: %s", err)
+		}
+		conn.Close()
+
+		var got []uint64
+		for _, sh := range resp.Shards {
+			if want := fmt.Sprintf("db0/rp0/%d", sh.ID); sh.Path != want {
+				t.Errorf("unexpected path for shard %d: got=%s want=%s", sh.ID, sh.Path, want)
+			}
+			if !sh.LastModified.Equal(lastModified[sh.ID]) {
+				t.Errorf("unexpected last modified for shard %d: got=%s want=%s", sh.ID, sh.LastModified, lastModified[sh.ID])
+			}
+			got = append(got, sh.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unexpected shards for since=%s: got=%v want=%v", tt.since, got, tt.want)
+		}
+	}
+}
+
 func TestSnapshotter_InvalidRequest(t *testing.T) {
 	s, l, err := NewTestService()
 	if err != nil {
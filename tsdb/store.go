@@ -864,6 +864,16 @@ func (s *Store) ShardRelativePath(id uint64) (string, error) {
 	return relativePath(s.path, shard.path)
 }
 
+// ShardLastModified returns the time the shard was last modified. The zero
+// time is returned if the shard's engine is not open.
+func (s *Store) ShardLastModified(id uint64) (time.Time, error) {
+	shard := s.Shard(id)
+	if shard == nil {
+		return time.Time{}, fmt.Errorf("shard %d doesn't exist on this server", id)
+	}
+	return shard.LastModified(), nil
+}
+
 // DeleteSeries loops through the local shards and deletes the series data for
 // the passed in series keys.
 func (s *Store) DeleteSeries(database string, sources []influxql.Source, condition influxql.Expr) error {
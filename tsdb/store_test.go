@@ -197,6 +197,41 @@ func TestStore_CreateShardSnapShot(t *testing.T) {
 	}
 }
 
+// Ensure the store returns the last modified time of a shard.
+func TestStore_ShardLastModified(t *testing.T) {
+	t.Parallel()
+
+	test := func(index string) {
+		s := MustOpenStore(index)
+		defer s.Close()
+
+		if _, err := s.ShardLastModified(1); err == nil {
+			t.Fatal("expected error for missing shard")
+		}
+
+		s.MustCreateShardWithData("db0", "rp0", 1, "cpu value=1 0")
+		if lastModified, err := s.ShardLastModified(1); err != nil {
+			t.Fatal(err)
+		} else if lastModified.IsZero() {
+			t.Fatal("expected last modified time")
+		}
+
+		// The last modified time is unknown once the engine is closed.
+		if err := s.Shard(1).Close(); err != nil {
+			t.Fatal(err)
+		}
+		if lastModified, err := s.ShardLastModified(1); err != nil {
+			t.Fatal(err)
+		} else if !lastModified.IsZero() {
+			t.Fatalf("expected zero time, got %s", lastModified)
+		}
+	}
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) { test(index) })
+	}
+}
+
 func TestStore_Open(t *testing.T) {
 	t.Parallel()
 